@@ -0,0 +1,67 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sorintlab/stolon/pkg/cluster"
+)
+
+// MigrateConfig reads the NilConfig stored at configKey, and if it's not at
+// cluster.CurrentCfgFormatVersion, saves a backup of the raw pre-migration
+// data under backupKeyPrefix and writes the migrated config back with a
+// CompareAndSwap, so a config change racing with the migration is detected
+// instead of silently lost. It's a no-op if the stored config is already at
+// the current format version.
+func MigrateConfig(store Store, configKey, backupKeyPrefix string) error {
+	raw, modIndex, err := store.Get(configKey)
+	if err != nil {
+		return fmt.Errorf("failed to read cluster config at %q: %v", configKey, err)
+	}
+
+	version, err := cluster.CfgFormatVersion(raw)
+	if err != nil {
+		return fmt.Errorf("failed to inspect cluster config format version: %v", err)
+	}
+	if version == cluster.CurrentCfgFormatVersion {
+		fmt.Printf("cluster config is already at format version %d, nothing to do\n", version)
+		return nil
+	}
+
+	// Unmarshalling a NilConfig already runs the needed migrations in
+	// memory; marshalling it back out writes it at CurrentCfgFormatVersion.
+	var nc cluster.NilConfig
+	if err := json.Unmarshal(raw, &nc); err != nil {
+		return fmt.Errorf("failed to migrate cluster config: %v", err)
+	}
+	migrated, err := json.Marshal(&nc)
+	if err != nil {
+		return err
+	}
+
+	backupKey := fmt.Sprintf("%s/%d", backupKeyPrefix, modIndex)
+	if err := store.Put(backupKey, raw); err != nil {
+		return fmt.Errorf("failed to save pre-migration backup at %q: %v", backupKey, err)
+	}
+
+	if err := store.CompareAndSwap(configKey, migrated, modIndex); err != nil {
+		return fmt.Errorf("failed to write migrated cluster config: %v (backup left at %q, safe to retry)", err, backupKey)
+	}
+
+	fmt.Printf("migrated cluster config from format version %d to %d (backup saved at %q)\n", version, cluster.CurrentCfgFormatVersion, backupKey)
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sorintlab/stolon/pkg/cluster"
+)
+
+// PatchType selects which RFC a PatchConfig call applies.
+type PatchType string
+
+const (
+	PatchTypeJSON  PatchType = "json"
+	PatchTypeMerge PatchType = "merge"
+)
+
+// PatchConfig reads the NilConfig stored at configKey, applies patch as
+// either a RFC 6902 JSON Patch or a RFC 7396 JSON Merge Patch depending on
+// patchType, and writes the result back with a CompareAndSwap loop: if a
+// concurrent writer updates the store between the read and the write,
+// PatchConfig re-reads the new value, re-applies the patch on top of it and
+// retries, so concurrent "stolonctl config patch" invocations don't clobber
+// each other. If dryRun is true, it returns the resulting NilConfig without
+// writing it back.
+func PatchConfig(store Store, configKey string, patchType PatchType, patch []byte, dryRun bool) (*cluster.NilConfig, error) {
+	for {
+		raw, modIndex, err := store.Get(configKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cluster config at %q: %v", configKey, err)
+		}
+
+		var nc cluster.NilConfig
+		if err := json.Unmarshal(raw, &nc); err != nil {
+			return nil, fmt.Errorf("failed to parse stored cluster config: %v", err)
+		}
+
+		var patched *cluster.NilConfig
+		switch patchType {
+		case PatchTypeJSON:
+			patched, err = nc.ApplyJSONPatch(patch)
+		case PatchTypeMerge:
+			patched, err = nc.ApplyMergePatch(patch)
+		default:
+			return nil, fmt.Errorf("unknown patch type %q", patchType)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply patch: %v", err)
+		}
+
+		if dryRun {
+			return patched, nil
+		}
+
+		newRaw, err := json.Marshal(patched)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.CompareAndSwap(configKey, newRaw, modIndex); err != nil {
+			if err == ErrCompareFailed {
+				// Someone else updated the config concurrently: re-read and
+				// retry the patch on top of their change.
+				continue
+			}
+			return nil, fmt.Errorf("failed to write patched cluster config: %v", err)
+		}
+		return patched, nil
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/sorintlab/stolon/pkg/cluster"
+)
+
+const configKey = "/stolon/cluster/config"
+
+func TestPatchConfigMergeAddsAndRemovesPGParameters(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	nc := &cluster.NilConfig{
+		PGParameters: cluster.MapStringP(map[string]string{"work_mem": "4MB"}),
+	}
+	raw, err := json.Marshal(nc)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := store.Put(configKey, raw); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	patched, err := PatchConfig(store, configKey, PatchTypeMerge, []byte(`{"pg_parameters":{"work_mem":null,"max_connections":"200"}}`), false)
+	if err != nil {
+		t.Fatalf("PatchConfig: %v", err)
+	}
+	want := map[string]string{"max_connections": "200"}
+	if got := *patched.PGParameters; !mapsEqual(got, want) {
+		t.Errorf("pg_parameters = %v, want %v", got, want)
+	}
+
+	stored, _, err := store.Get(configKey)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	var nc2 cluster.NilConfig
+	if err := json.Unmarshal(stored, &nc2); err != nil {
+		t.Fatalf("Unmarshal stored config: %v", err)
+	}
+	if got := *nc2.PGParameters; !mapsEqual(got, want) {
+		t.Errorf("stored pg_parameters = %v, want %v", got, want)
+	}
+}
+
+func TestPatchConfigDryRunDoesNotWriteBack(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	nc := &cluster.NilConfig{PGParameters: cluster.MapStringP(map[string]string{"work_mem": "4MB"})}
+	raw, err := json.Marshal(nc)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := store.Put(configKey, raw); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	patched, err := PatchConfig(store, configKey, PatchTypeMerge, []byte(`{"pg_parameters":{"max_connections":"200"}}`), true)
+	if err != nil {
+		t.Fatalf("PatchConfig: %v", err)
+	}
+	if _, ok := (*patched.PGParameters)["max_connections"]; !ok {
+		t.Error("dry-run result should include the patched field")
+	}
+
+	stored, _, err := store.Get(configKey)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(stored) != string(raw) {
+		t.Errorf("dry-run must not write back, got stored = %s, want unchanged %s", stored, raw)
+	}
+}
+
+// fakeCASRaceStore simulates a single concurrent writer stepping in between
+// PatchConfig's read and its first CompareAndSwap attempt, so PatchConfig's
+// retry loop is exercised: the first CompareAndSwap call fails as if another
+// client patched the config first, and the second (with the now-current
+// modIndex) must succeed and reapply the patch on top of that change.
+type fakeCASRaceStore struct {
+	value        []byte
+	modIndex     uint64
+	casAttempts  int
+	concurrentAt int
+	concurrent   []byte
+}
+
+func (s *fakeCASRaceStore) Get(key string) ([]byte, uint64, error) {
+	return s.value, s.modIndex, nil
+}
+
+func (s *fakeCASRaceStore) Put(key string, value []byte) error {
+	s.value = value
+	s.modIndex++
+	return nil
+}
+
+func (s *fakeCASRaceStore) CompareAndSwap(key string, value []byte, prevModIndex uint64) error {
+	s.casAttempts++
+	if s.casAttempts == s.concurrentAt {
+		s.value = s.concurrent
+		s.modIndex++
+		return ErrCompareFailed
+	}
+	if prevModIndex != s.modIndex {
+		return ErrCompareFailed
+	}
+	s.value = value
+	s.modIndex++
+	return nil
+}
+
+func TestPatchConfigRetriesOnConcurrentWrite(t *testing.T) {
+	initial := &cluster.NilConfig{PGParameters: cluster.MapStringP(map[string]string{"work_mem": "4MB"})}
+	initialRaw, err := json.Marshal(initial)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	concurrent := &cluster.NilConfig{PGParameters: cluster.MapStringP(map[string]string{
+		"work_mem":        "4MB",
+		"max_connections": "50",
+	})}
+	concurrentRaw, err := json.Marshal(concurrent)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	store := &fakeCASRaceStore{value: initialRaw, modIndex: 1, concurrentAt: 1, concurrent: concurrentRaw}
+
+	patched, err := PatchConfig(store, configKey, PatchTypeMerge, []byte(`{"pg_parameters":{"shared_buffers":"1GB"}}`), false)
+	if err != nil {
+		t.Fatalf("PatchConfig: %v", err)
+	}
+	if store.casAttempts != 2 {
+		t.Errorf("casAttempts = %d, want 2 (one failed, one retried)", store.casAttempts)
+	}
+	want := map[string]string{"work_mem": "4MB", "max_connections": "50", "shared_buffers": "1GB"}
+	if got := *patched.PGParameters; !mapsEqual(got, want) {
+		t.Errorf("pg_parameters = %v, want %v (patch reapplied on top of the concurrent write)", got, want)
+	}
+}
+
+func mapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
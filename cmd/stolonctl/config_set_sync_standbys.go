@@ -0,0 +1,101 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// tagsFlag accumulates repeated --tag key=value flags into a map, used to
+// build the synchronous_standby_tags merge patch value the same way
+// cluster config persists it (cluster.NilConfig.SynchronousStandbyTags).
+type tagsFlag map[string]string
+
+func (t tagsFlag) String() string {
+	return fmt.Sprintf("%v", map[string]string(t))
+}
+
+func (t tagsFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("--tag must be of the form key=value, got %q", s)
+	}
+	t[k] = v
+	return nil
+}
+
+// runConfigSetSyncStandbys applies only the synchronous standby tuning
+// flags given on the command line as a JSON Merge Patch, leaving every
+// other field of the stored cluster config untouched.
+func runConfigSetSyncStandbys(args []string) error {
+	fs := flag.NewFlagSet("config set sync-standbys", flag.ExitOnError)
+	storeDir := fs.String("store-dir", "", "directory backing the local cluster store")
+	configKey := fs.String("config-key", defaultConfigKey, "store key holding the cluster config")
+	min := fs.Uint("min", 0, "min_synchronous_standbys (0 leaves it unchanged)")
+	max := fs.Uint("max", 0, "max_synchronous_standbys (0 leaves it unchanged)")
+	policy := fs.String("policy", "", "synchronous_standbys_policy: any, first or quorum (empty leaves it unchanged)")
+	tags := tagsFlag{}
+	fs.Var(tags, "tag", "keeper tag required of eligible synchronous standbys (key=value, repeatable; merged into the existing tag set, keys not given are kept)")
+	dryRun := fs.Bool("dry-run", false, "print the resulting cluster config instead of writing it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	patchFields := map[string]interface{}{}
+	if *min != 0 {
+		patchFields["min_synchronous_standbys"] = *min
+	}
+	if *max != 0 {
+		patchFields["max_synchronous_standbys"] = *max
+	}
+	if *policy != "" {
+		patchFields["synchronous_standbys_policy"] = *policy
+	}
+	if len(tags) > 0 {
+		patchFields["synchronous_standby_tags"] = map[string]string(tags)
+	}
+	if len(patchFields) == 0 {
+		return fmt.Errorf("at least one of --min, --max, --policy or --tag is required")
+	}
+	patch, err := json.Marshal(patchFields)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(*storeDir)
+	if err != nil {
+		return err
+	}
+
+	patched, err := PatchConfig(store, *configKey, PatchTypeMerge, patch, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	after, err := json.MarshalIndent(patched, "", "  ")
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Println("resulting cluster config:")
+		fmt.Println(string(after))
+		return nil
+	}
+	fmt.Println("cluster config patched")
+	return nil
+}
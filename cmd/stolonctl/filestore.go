@@ -0,0 +1,143 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FileStore is a Store backed by one file per key in a local directory,
+// with each key's ModIndex tracked in a sibling ".modindex" file fed by a
+// single store-wide counter (also persisted, so it survives a restart).
+// It's meant as a simple, dependency-free default for single-node/test use;
+// production deployments should back Store with the cluster's real
+// etcd/consul store.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileStore returns a FileStore rooted at dir, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.dir, url.PathEscape(key))
+}
+
+func (s *FileStore) modIndexPath(key string) string {
+	return s.path(key) + ".modindex"
+}
+
+func (s *FileStore) counterPath() string {
+	return filepath.Join(s.dir, ".modindex-counter")
+}
+
+// nextModIndex returns a store-wide monotonically increasing ModIndex,
+// persisting the counter so it keeps increasing across process restarts. A
+// file mtime isn't precise enough for this: back-to-back writes can land
+// within the same mtime tick and collide, which would make CompareAndSwap
+// silently accept a write it should have rejected as stale.
+func (s *FileStore) nextModIndex() (uint64, error) {
+	cur, err := s.readCounter()
+	if err != nil {
+		return 0, err
+	}
+	next := cur + 1
+	if err := os.WriteFile(s.counterPath(), []byte(strconv.FormatUint(next, 10)), 0600); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+func (s *FileStore) readCounter() (uint64, error) {
+	b, err := os.ReadFile(s.counterPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// Get implements Store.
+func (s *FileStore) Get(key string) ([]byte, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(key)
+}
+
+func (s *FileStore) get(key string) ([]byte, uint64, error) {
+	b, err := os.ReadFile(s.path(key))
+	if err != nil {
+		return nil, 0, err
+	}
+	mb, err := os.ReadFile(s.modIndexPath(key))
+	if err != nil {
+		return nil, 0, err
+	}
+	modIndex, err := strconv.ParseUint(strings.TrimSpace(string(mb)), 10, 64)
+	if err != nil {
+		return nil, 0, err
+	}
+	return b, modIndex, nil
+}
+
+// write persists value at key under a freshly allocated ModIndex.
+func (s *FileStore) write(key string, value []byte) error {
+	modIndex, err := s.nextModIndex()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path(key), value, 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(s.modIndexPath(key), []byte(strconv.FormatUint(modIndex, 10)), 0600)
+}
+
+// Put implements Store.
+func (s *FileStore) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(key, value)
+}
+
+// CompareAndSwap implements Store.
+func (s *FileStore) CompareAndSwap(key string, value []byte, prevModIndex uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, modIndex, err := s.get(key)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if prevModIndex != 0 {
+			return ErrCompareFailed
+		}
+	} else if modIndex != prevModIndex {
+		return ErrCompareFailed
+	}
+	return s.write(key, value)
+}
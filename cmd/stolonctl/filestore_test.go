@@ -0,0 +1,104 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+// TestFileStoreRapidWritesGetDistinctModIndexes guards against the
+// file-mtime-based ModIndex this replaced: two writes issued back-to-back
+// (well within a single mtime tick on most filesystems) must still produce
+// distinct ModIndexes, or a CompareAndSwap racing with either
+// write could wrongly succeed against stale data.
+func TestFileStoreRapidWritesGetDistinctModIndexes(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := store.Put("k", []byte("v1")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, modIndex1, err := store.Get("k")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if err := store.Put("k", []byte("v2")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, modIndex2, err := store.Get("k")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if modIndex1 == modIndex2 {
+		t.Fatalf("expected distinct ModIndexes across back-to-back writes, got %d twice", modIndex1)
+	}
+}
+
+func TestFileStoreCompareAndSwapDetectsStaleModIndex(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := store.Put("k", []byte("v1")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, modIndex, err := store.Get("k")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	// Someone else updates the key concurrently.
+	if err := store.Put("k", []byte("v2")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.CompareAndSwap("k", []byte("v3"), modIndex); err != ErrCompareFailed {
+		t.Fatalf("CompareAndSwap against a stale ModIndex = %v, want ErrCompareFailed", err)
+	}
+}
+
+func TestFileStoreCompareAndSwapSucceedsOnCurrentModIndex(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := store.Put("k", []byte("v1")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, modIndex, err := store.Get("k")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if err := store.CompareAndSwap("k", []byte("v2"), modIndex); err != nil {
+		t.Fatalf("CompareAndSwap: %v", err)
+	}
+	got, _, err := store.Get("k")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(got) != "v2" {
+		t.Errorf("value = %q, want %q", got, "v2")
+	}
+}
+
+func TestFileStoreCompareAndSwapOnMissingKeyRequiresZeroModIndex(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	if err := store.CompareAndSwap("missing", []byte("v1"), 1); err != ErrCompareFailed {
+		t.Fatalf("CompareAndSwap against a missing key with nonzero ModIndex = %v, want ErrCompareFailed", err)
+	}
+	if err := store.CompareAndSwap("missing", []byte("v1"), 0); err != nil {
+		t.Fatalf("CompareAndSwap against a missing key with ModIndex 0: %v", err)
+	}
+}
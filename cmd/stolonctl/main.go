@@ -0,0 +1,165 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command stolonctl is stolon's cluster administration CLI.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const (
+	defaultConfigKey    = "/stolon/cluster/config"
+	defaultBackupPrefix = "/stolon/cluster/config-backups"
+)
+
+func main() {
+	if len(os.Args) < 3 || os.Args[1] != "config" {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[2] {
+	case "migrate":
+		err = runConfigMigrate(os.Args[3:])
+	case "patch":
+		err = runConfigPatch(os.Args[3:])
+	case "set":
+		if len(os.Args) < 4 || os.Args[3] != "sync-standbys" {
+			usage()
+			os.Exit(2)
+		}
+		err = runConfigSetSyncStandbys(os.Args[4:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: stolonctl config migrate [--store-dir DIR] [--config-key KEY] [--backup-prefix PREFIX]")
+	fmt.Fprintln(os.Stderr, "       stolonctl config patch --type=json|merge -f patch.json [--dry-run] [--store-dir DIR] [--config-key KEY]")
+	fmt.Fprintln(os.Stderr, "       stolonctl config set sync-standbys [--min N] [--max N] [--policy any|first|quorum] [--tag key=value ...] [--dry-run] [--store-dir DIR] [--config-key KEY]")
+}
+
+func openStore(storeDir string) (Store, error) {
+	return NewFileStore(storeDir)
+}
+
+func runConfigMigrate(args []string) error {
+	fs := flag.NewFlagSet("config migrate", flag.ExitOnError)
+	storeDir := fs.String("store-dir", "", "directory backing the local cluster store")
+	configKey := fs.String("config-key", defaultConfigKey, "store key holding the cluster config")
+	backupPrefix := fs.String("backup-prefix", defaultBackupPrefix, "store key prefix to save a pre-migration backup under")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := openStore(*storeDir)
+	if err != nil {
+		return err
+	}
+	return MigrateConfig(store, *configKey, *backupPrefix)
+}
+
+func runConfigPatch(args []string) error {
+	fs := flag.NewFlagSet("config patch", flag.ExitOnError)
+	storeDir := fs.String("store-dir", "", "directory backing the local cluster store")
+	configKey := fs.String("config-key", defaultConfigKey, "store key holding the cluster config")
+	patchType := fs.String("type", "", "patch type: json or merge")
+	patchFile := fs.String("f", "", "path to the patch document")
+	dryRun := fs.Bool("dry-run", false, "print the resulting cluster config instead of writing it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *patchFile == "" {
+		return fmt.Errorf("-f is required")
+	}
+	patch, err := os.ReadFile(*patchFile)
+	if err != nil {
+		return fmt.Errorf("failed to read patch file: %v", err)
+	}
+
+	var pt PatchType
+	switch *patchType {
+	case "json":
+		pt = PatchTypeJSON
+	case "merge":
+		pt = PatchTypeMerge
+	default:
+		return fmt.Errorf("--type must be \"json\" or \"merge\"")
+	}
+
+	store, err := openStore(*storeDir)
+	if err != nil {
+		return err
+	}
+
+	before, _, err := store.Get(*configKey)
+	if err != nil {
+		return fmt.Errorf("failed to read cluster config at %q: %v", *configKey, err)
+	}
+
+	patched, err := PatchConfig(store, *configKey, pt, patch, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	after, err := json.MarshalIndent(patched, "", "  ")
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Println("resulting cluster config:")
+		fmt.Println(string(after))
+		fmt.Println("diff (before -> after):")
+		printDiff(before, after)
+		return nil
+	}
+	fmt.Println("cluster config patched")
+	return nil
+}
+
+// printDiff prints the before and after JSON documents in full, or
+// "(no changes)" if they're equal; it's a readability aid for --dry-run and
+// doesn't compute a line-by-line diff.
+func printDiff(before, after []byte) {
+	var beforeDoc, afterDoc map[string]interface{}
+	if err := json.Unmarshal(before, &beforeDoc); err != nil {
+		return
+	}
+	if err := json.Unmarshal(after, &afterDoc); err != nil {
+		return
+	}
+	beforeLines, _ := json.MarshalIndent(beforeDoc, "", "  ")
+	afterLines, _ := json.MarshalIndent(afterDoc, "", "  ")
+	if bytes.Equal(beforeLines, afterLines) {
+		fmt.Println("(no changes)")
+		return
+	}
+	fmt.Println("--- before")
+	fmt.Println(string(beforeLines))
+	fmt.Println("+++ after")
+	fmt.Println(string(afterLines))
+}
@@ -0,0 +1,37 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "errors"
+
+// ErrCompareFailed is returned by Store.CompareAndSwap when the value at
+// key changed since prevModIndex was read.
+var ErrCompareFailed = errors.New("compare failed: value has been concurrently modified")
+
+// Store is the minimal key/value interface the "config migrate" and
+// "config patch" commands need against the cluster store. The
+// keeper/sentinel/proxy processes talk to the real etcd/consul backed store
+// through the same kind of interface; FileStore here is a small local
+// implementation used as the default store backend and in tests.
+type Store interface {
+	// Get returns the value stored at key and an opaque ModIndex that
+	// changes every time the value is written.
+	Get(key string) (value []byte, modIndex uint64, err error)
+	// Put unconditionally writes value at key.
+	Put(key string, value []byte) error
+	// CompareAndSwap writes value at key iff the current ModIndex still
+	// equals prevModIndex, returning ErrCompareFailed otherwise.
+	CompareAndSwap(key string, value []byte, prevModIndex uint64) error
+}
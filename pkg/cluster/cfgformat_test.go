@@ -0,0 +1,75 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import "testing"
+
+func TestUnmarshalJSONLegacyEnvelopeLessFormat(t *testing.T) {
+	legacy := []byte(`{"max_standbys_per_sender":5,"synchronous_replication":true}`)
+
+	var nc NilConfig
+	if err := (&nc).UnmarshalJSON(legacy); err != nil {
+		t.Fatalf("UnmarshalJSON of envelope-less legacy config: %v", err)
+	}
+	if got := *nc.MaxStandbysPerSender; got != 5 {
+		t.Errorf("max_standbys_per_sender = %d, want 5", got)
+	}
+	if got := *nc.SynchronousReplication; !got {
+		t.Errorf("synchronous_replication = %v, want true", got)
+	}
+}
+
+func TestCfgFormatVersionLegacyEnvelopeLessFormat(t *testing.T) {
+	legacy := []byte(`{"max_standbys_per_sender":5}`)
+	version, err := CfgFormatVersion(legacy)
+	if err != nil {
+		t.Fatalf("CfgFormatVersion: %v", err)
+	}
+	if version != 0 {
+		t.Errorf("version = %d, want 0", version)
+	}
+}
+
+func TestUnmarshalJSONRejectsOlderThanMinFormatVersion(t *testing.T) {
+	ancient := []byte(`{"format_version":-1,"config":{}}`)
+	var nc NilConfig
+	if err := (&nc).UnmarshalJSON(ancient); err == nil {
+		t.Fatal("expected an error unmarshalling a format version older than MinCfgFormatVersion")
+	}
+}
+
+func TestUnmarshalJSONRejectsNewerFormatVersion(t *testing.T) {
+	future := []byte(`{"format_version":99,"config":{}}`)
+	var nc NilConfig
+	if err := (&nc).UnmarshalJSON(future); err == nil {
+		t.Fatal("expected an error unmarshalling a newer-than-supported format version")
+	}
+}
+
+func TestUnmarshalJSONRoundTripsCurrentFormatVersion(t *testing.T) {
+	nc := &NilConfig{MaxStandbysPerSender: UintP(7)}
+	marshaled, err := nc.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON: %v", err)
+	}
+
+	var nc2 NilConfig
+	if err := (&nc2).UnmarshalJSON(marshaled); err != nil {
+		t.Fatalf("UnmarshalJSON: %v", err)
+	}
+	if got := *nc2.MaxStandbysPerSender; got != 7 {
+		t.Errorf("max_standbys_per_sender = %d, want 7", got)
+	}
+}
@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/sorintlab/stolon/pkg/cluster/migrations"
 )
 
 const (
@@ -31,6 +33,36 @@ const (
 	DefaultSynchronousReplication  = false
 	DefaultInitWithMultipleKeepers = false
 	DefaultUsePGRewind             = false
+
+	DefaultMinSynchronousStandbys    = 1
+	DefaultMaxSynchronousStandbys    = 1
+	DefaultSynchronousStandbysPolicy = SyncStandbysPolicyAny
+
+	// CurrentCfgFormatVersion is the on-disk NilConfig format version written
+	// by this binary.
+	CurrentCfgFormatVersion = 1
+	// MinCfgFormatVersion is the oldest on-disk NilConfig format version this
+	// binary can read (older versions need "stolonctl config migrate" run
+	// with a previous stolon version first). Format version 0 is the
+	// pre-versioning, envelope-less data written by stolon versions before
+	// cfgFormat existed, so it must stay readable here rather than bumping
+	// this to 1.
+	MinCfgFormatVersion = 0
+)
+
+// Synchronous standbys selection policies usable as
+// NilConfig.SynchronousStandbysPolicy, mirroring PostgreSQL's
+// synchronous_standby_names forms.
+const (
+	// SyncStandbysPolicyAny picks any MinSynchronousStandbys..MaxSynchronousStandbys
+	// eligible standbys ("ANY k (...)").
+	SyncStandbysPolicyAny = "any"
+	// SyncStandbysPolicyFirst picks the first MinSynchronousStandbys..MaxSynchronousStandbys
+	// eligible standbys in priority order ("FIRST k (...)").
+	SyncStandbysPolicyFirst = "first"
+	// SyncStandbysPolicyQuorum requires at least MinSynchronousStandbys eligible
+	// standbys to be reachable before promoting/accepting a new master.
+	SyncStandbysPolicyQuorum = "quorum"
 )
 
 // NilConfig is the cluster configuration with all the values as pointer. Having
@@ -50,6 +82,20 @@ type NilConfig struct {
 	InitWithMultipleKeepers *bool              `json:"init_with_multiple_keepers,omitempty"`
 	UsePGRewind             *bool              `json:"use_pg_rewind,omitempty"`
 	PGParameters            *map[string]string `json:"pg_parameters,omitempty"`
+
+	// MinSynchronousStandbys is the minimum number of synchronous standbys
+	// required when SynchronousReplication is true.
+	MinSynchronousStandbys *uint `json:"min_synchronous_standbys,omitempty"`
+	// MaxSynchronousStandbys is the maximum number of synchronous standbys
+	// to request when SynchronousReplication is true.
+	MaxSynchronousStandbys *uint `json:"max_synchronous_standbys,omitempty"`
+	// SynchronousStandbysPolicy selects how eligible standbys are picked and
+	// how synchronous_standby_names is built. One of SyncStandbysPolicyAny,
+	// SyncStandbysPolicyFirst or SyncStandbysPolicyQuorum.
+	SynchronousStandbysPolicy *string `json:"synchronous_standbys_policy,omitempty"`
+	// SynchronousStandbyTags, if not empty, restricts the keepers eligible as
+	// synchronous standbys to those whose tags match (e.g. by DC).
+	SynchronousStandbyTags *map[string]string `json:"synchronous_standby_tags,omitempty"`
 }
 
 // Config is the cluster configuration taken from a NilConfig and populated with
@@ -72,6 +118,17 @@ type Config struct {
 	UsePGRewind bool
 	// Map of postgres parameters
 	PGParameters map[string]string
+	// Minimum number of synchronous standbys required when
+	// SynchronousReplication is true.
+	MinSynchronousStandbys uint
+	// Maximum number of synchronous standbys to request when
+	// SynchronousReplication is true.
+	MaxSynchronousStandbys uint
+	// Policy used to pick eligible synchronous standbys and build
+	// synchronous_standby_names (any, first or quorum).
+	SynchronousStandbysPolicy string
+	// Tags a keeper must match to be eligible as a synchronous standby.
+	SynchronousStandbyTags map[string]string
 }
 
 // StringP is a helper function that returns the address of a copy of the
@@ -108,14 +165,96 @@ func MapStringP(m map[string]string) *map[string]string {
 	return &nm
 }
 
-// UnmarshalJSON implements the encoding/json.Unmarshaler interface. After
-// unmarshalling it also validates the NilConfig.
+// cfgFormat is the on-disk envelope wrapping a NilConfig with the format
+// version it was written with. This lets stolon detect and migrate cluster
+// config data written by an older stolon version, and refuse to start
+// against data written by a newer, not-yet-understood one.
+type cfgFormat struct {
+	FormatVersion int             `json:"format_version"`
+	Config        json.RawMessage `json:"config"`
+}
+
+// CfgFormatVersion returns the format_version recorded in the cfgFormat
+// envelope of a persisted NilConfig, without fully unmarshalling (and so,
+// transparently migrating in memory) its contents. Envelope-less data
+// (written before cfgFormat existed) reports as format version 0. "stolonctl
+// config migrate" uses this to decide whether the stored config needs to be
+// rewritten at all.
+func CfgFormatVersion(raw []byte) (int, error) {
+	version, _, err := splitCfgFormat(raw)
+	return version, err
+}
+
+// splitCfgFormat parses a persisted NilConfig's format_version and the
+// format-specific payload that goes with it. Data predating the cfgFormat
+// envelope has no "format_version" key at all; that's reported as format
+// version 0, with the whole document as the payload, so it can be fed
+// straight into the migrations chain instead of being rejected outright.
+func splitCfgFormat(in []byte) (version int, raw json.RawMessage, err error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(in, &probe); err != nil {
+		return 0, nil, err
+	}
+	fv, ok := probe["format_version"]
+	if !ok {
+		return 0, in, nil
+	}
+	if err := json.Unmarshal(fv, &version); err != nil {
+		return 0, nil, fmt.Errorf("invalid format_version: %v", err)
+	}
+	config, ok := probe["config"]
+	if !ok {
+		return 0, nil, fmt.Errorf("cluster config format version %d envelope is missing its config payload", version)
+	}
+	return version, config, nil
+}
+
+// MarshalJSON implements the encoding/json.Marshaler interface, wrapping the
+// NilConfig in a cfgFormat envelope at CurrentCfgFormatVersion.
+func (c *NilConfig) MarshalJSON() ([]byte, error) {
+	// nilConfig is needed to avoid recursive infinite calls to
+	// NilConfig.MarshalJSON
+	type nilConfig NilConfig
+	config, err := json.Marshal((*nilConfig)(c))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(cfgFormat{
+		FormatVersion: CurrentCfgFormatVersion,
+		Config:        config,
+	})
+}
+
+// UnmarshalJSON implements the encoding/json.Unmarshaler interface. Data
+// written before cfgFormat existed has no envelope at all (the whole
+// document is the flat NilConfig fields); splitCfgFormat reports that shape
+// as format version 0. UnmarshalJSON refuses a format version outside the
+// [MinCfgFormatVersion, CurrentCfgFormatVersion] range this binary
+// understands, runs any needed migrations and validates the result.
 func (c *NilConfig) UnmarshalJSON(in []byte) error {
+	version, raw, err := splitCfgFormat(in)
+	if err != nil {
+		return err
+	}
+	if version > CurrentCfgFormatVersion {
+		return fmt.Errorf("cluster config format version %d is newer than the max supported format version %d: upgrade stolon", version, CurrentCfgFormatVersion)
+	}
+	if version < MinCfgFormatVersion {
+		return fmt.Errorf("cluster config format version %d is older than the min supported format version %d: run \"stolonctl config migrate\" with a previous stolon version first", version, MinCfgFormatVersion)
+	}
+	if version < CurrentCfgFormatVersion {
+		migrated, err := migrations.Migrate(raw, version, CurrentCfgFormatVersion)
+		if err != nil {
+			return fmt.Errorf("failed to migrate cluster config: %v", err)
+		}
+		raw = migrated
+	}
+
 	// nilConfig is needed to avoid recursive infinite calls to
 	// NilConfig.UnmarshalJSON
 	type nilConfig NilConfig
 	var nc nilConfig
-	if err := json.Unmarshal(in, &nc); err != nil {
+	if err := json.Unmarshal(raw, &nc); err != nil {
 		return err
 	}
 	*c = NilConfig(nc)
@@ -155,6 +294,18 @@ func (c *NilConfig) Copy() *NilConfig {
 	if c.PGParameters != nil {
 		nc.PGParameters = MapStringP(*c.PGParameters)
 	}
+	if c.MinSynchronousStandbys != nil {
+		nc.MinSynchronousStandbys = UintP(*c.MinSynchronousStandbys)
+	}
+	if c.MaxSynchronousStandbys != nil {
+		nc.MaxSynchronousStandbys = UintP(*c.MaxSynchronousStandbys)
+	}
+	if c.SynchronousStandbysPolicy != nil {
+		nc.SynchronousStandbysPolicy = StringP(*c.SynchronousStandbysPolicy)
+	}
+	if c.SynchronousStandbyTags != nil {
+		nc.SynchronousStandbyTags = MapStringP(*c.SynchronousStandbyTags)
+	}
 	return &nc
 }
 
@@ -205,6 +356,29 @@ func (c *NilConfig) Validate() error {
 	if c.MaxStandbysPerSender != nil && *c.MaxStandbysPerSender < 1 {
 		return fmt.Errorf("max_standbys_per_sender must be at least 1")
 	}
+	if c.MinSynchronousStandbys != nil && *c.MinSynchronousStandbys < 1 {
+		return fmt.Errorf("min_synchronous_standbys must be at least 1")
+	}
+	if c.MinSynchronousStandbys != nil && c.MaxSynchronousStandbys != nil && *c.MinSynchronousStandbys > *c.MaxSynchronousStandbys {
+		return fmt.Errorf("min_synchronous_standbys must be less than or equal to max_synchronous_standbys")
+	}
+	if c.SynchronousStandbysPolicy != nil {
+		switch *c.SynchronousStandbysPolicy {
+		case SyncStandbysPolicyAny, SyncStandbysPolicyFirst, SyncStandbysPolicyQuorum:
+		default:
+			return fmt.Errorf("synchronous_standbys_policy must be one of %q, %q or %q", SyncStandbysPolicyAny, SyncStandbysPolicyFirst, SyncStandbysPolicyQuorum)
+		}
+		if *c.SynchronousStandbysPolicy == SyncStandbysPolicyQuorum {
+			// A nil MinSynchronousStandbys is fine here: MergeDefaults will
+			// set it to DefaultMinSynchronousStandbys (1), which satisfies
+			// the requirement. Only an explicit invalid value is rejected;
+			// the general "must be at least 1" check above already covers
+			// that case.
+			if c.MinSynchronousStandbys != nil && *c.MinSynchronousStandbys < 1 {
+				return fmt.Errorf("synchronous_standbys_policy quorum requires min_synchronous_standbys >= 1")
+			}
+		}
+	}
 	return nil
 }
 
@@ -234,6 +408,18 @@ func (c *NilConfig) MergeDefaults() {
 	if c.PGParameters == nil {
 		c.PGParameters = &map[string]string{}
 	}
+	if c.MinSynchronousStandbys == nil {
+		c.MinSynchronousStandbys = UintP(DefaultMinSynchronousStandbys)
+	}
+	if c.MaxSynchronousStandbys == nil {
+		c.MaxSynchronousStandbys = UintP(DefaultMaxSynchronousStandbys)
+	}
+	if c.SynchronousStandbysPolicy == nil {
+		c.SynchronousStandbysPolicy = StringP(DefaultSynchronousStandbysPolicy)
+	}
+	if c.SynchronousStandbyTags == nil {
+		c.SynchronousStandbyTags = &map[string]string{}
+	}
 }
 
 // ToConfig returns a *Config from a *NilConfig (it'll be populated with all the
@@ -242,14 +428,18 @@ func (c *NilConfig) ToConfig() *Config {
 	nc := c.Copy()
 	nc.MergeDefaults()
 	return &Config{
-		RequestTimeout:          (*nc.RequestTimeout).Duration,
-		SleepInterval:           (*nc.SleepInterval).Duration,
-		KeeperFailInterval:      (*nc.KeeperFailInterval).Duration,
-		MaxStandbysPerSender:    *nc.MaxStandbysPerSender,
-		SynchronousReplication:  *nc.SynchronousReplication,
-		InitWithMultipleKeepers: *nc.InitWithMultipleKeepers,
-		UsePGRewind:             *nc.UsePGRewind,
-		PGParameters:            *nc.PGParameters,
+		RequestTimeout:            (*nc.RequestTimeout).Duration,
+		SleepInterval:             (*nc.SleepInterval).Duration,
+		KeeperFailInterval:        (*nc.KeeperFailInterval).Duration,
+		MaxStandbysPerSender:      *nc.MaxStandbysPerSender,
+		SynchronousReplication:    *nc.SynchronousReplication,
+		InitWithMultipleKeepers:   *nc.InitWithMultipleKeepers,
+		UsePGRewind:               *nc.UsePGRewind,
+		PGParameters:              *nc.PGParameters,
+		MinSynchronousStandbys:    *nc.MinSynchronousStandbys,
+		MaxSynchronousStandbys:    *nc.MaxSynchronousStandbys,
+		SynchronousStandbysPolicy: *nc.SynchronousStandbysPolicy,
+		SynchronousStandbyTags:    *nc.SynchronousStandbyTags,
 	}
 }
 
@@ -0,0 +1,82 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import "testing"
+
+func TestValidateQuorumPolicyAllowsUnsetMin(t *testing.T) {
+	nc := &NilConfig{SynchronousStandbysPolicy: StringP(SyncStandbysPolicyQuorum)}
+	if err := nc.Validate(); err != nil {
+		t.Fatalf("Validate() with unset MinSynchronousStandbys and quorum policy should succeed (MergeDefaults fills it in), got: %v", err)
+	}
+}
+
+func TestValidateQuorumPolicyRejectsExplicitZero(t *testing.T) {
+	nc := &NilConfig{
+		SynchronousStandbysPolicy: StringP(SyncStandbysPolicyQuorum),
+		MinSynchronousStandbys:    UintP(0),
+	}
+	if err := nc.Validate(); err == nil {
+		t.Fatal("Validate() should reject an explicit MinSynchronousStandbys of 0")
+	}
+}
+
+func TestValidateMinMaxOrder(t *testing.T) {
+	nc := &NilConfig{
+		MinSynchronousStandbys: UintP(3),
+		MaxSynchronousStandbys: UintP(2),
+	}
+	if err := nc.Validate(); err == nil {
+		t.Fatal("Validate() should reject min_synchronous_standbys > max_synchronous_standbys")
+	}
+}
+
+func TestValidateSynchronousStandbysPolicyEnum(t *testing.T) {
+	nc := &NilConfig{SynchronousStandbysPolicy: StringP("bogus")}
+	if err := nc.Validate(); err == nil {
+		t.Fatal("Validate() should reject an unknown synchronous_standbys_policy")
+	}
+}
+
+// TestMergeDefaultsSyncStandbysBackCompat ensures that when only
+// SynchronousReplication is set, MergeDefaults fills in
+// MinSynchronousStandbys=1, MaxSynchronousStandbys=1 and policy "any",
+// matching the old all-or-nothing synchronous_replication semantics.
+func TestMergeDefaultsSyncStandbysBackCompat(t *testing.T) {
+	nc := &NilConfig{SynchronousReplication: BoolP(true)}
+	nc.MergeDefaults()
+
+	if got := *nc.MinSynchronousStandbys; got != 1 {
+		t.Errorf("MinSynchronousStandbys = %d, want 1", got)
+	}
+	if got := *nc.MaxSynchronousStandbys; got != 1 {
+		t.Errorf("MaxSynchronousStandbys = %d, want 1", got)
+	}
+	if got := *nc.SynchronousStandbysPolicy; got != SyncStandbysPolicyAny {
+		t.Errorf("SynchronousStandbysPolicy = %q, want %q", got, SyncStandbysPolicyAny)
+	}
+}
+
+func TestToConfigSyncStandbysDefaults(t *testing.T) {
+	nc := &NilConfig{SynchronousReplication: BoolP(true)}
+	cfg := nc.ToConfig()
+
+	if cfg.MinSynchronousStandbys != 1 || cfg.MaxSynchronousStandbys != 1 {
+		t.Errorf("got min=%d max=%d, want min=1 max=1", cfg.MinSynchronousStandbys, cfg.MaxSynchronousStandbys)
+	}
+	if cfg.SynchronousStandbysPolicy != SyncStandbysPolicyAny {
+		t.Errorf("SynchronousStandbysPolicy = %q, want %q", cfg.SynchronousStandbysPolicy, SyncStandbysPolicyAny)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package migrations holds the ordered set of functions used to bring a
+// persisted cluster config, stored in etcd/consul under an older on-disk
+// format version, up to the format version understood by the running
+// stolon binary. It's consumed by NilConfig.UnmarshalJSON (to transparently
+// read old data) and by "stolonctl config migrate" (to rewrite the stored
+// data in place).
+package migrations
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MigrateFunc transforms a serialized NilConfig from one on-disk format
+// version to the next one (N -> N+1): field renames, default changes, field
+// splits, etc.
+type MigrateFunc func(raw json.RawMessage) (json.RawMessage, error)
+
+// migrations[i] migrates from format version i to i+1. migrations[0]
+// migrates the pre-versioning, envelope-less on-disk format (format version
+// 0) into format version 1.
+var migrations = []MigrateFunc{
+	migrateV0ToV1,
+}
+
+// migrateV0ToV1 migrates the pre-versioning on-disk format (raw is the flat
+// NilConfig fields, with no cfgFormat envelope) to format version 1.
+// Introducing the envelope didn't change the field representation itself,
+// so this is the identity transform; it exists so the migration chain has a
+// slot to extend if version 1's fields ever need adjusting when read from
+// legacy data.
+func migrateV0ToV1(raw json.RawMessage) (json.RawMessage, error) {
+	return raw, nil
+}
+
+// Migrate runs every migration needed to bring raw, currently at
+// fromVersion, up to toVersion, returning the migrated JSON. It's a no-op if
+// fromVersion == toVersion and toVersion is a format version this package
+// has migrations up to; a toVersion beyond that is always an error.
+func Migrate(raw json.RawMessage, fromVersion, toVersion int) (json.RawMessage, error) {
+	if fromVersion > toVersion {
+		return nil, fmt.Errorf("cannot migrate from format version %d to an older format version %d", fromVersion, toVersion)
+	}
+	if toVersion > len(migrations) {
+		return nil, fmt.Errorf("no migration available to format version %d", toVersion)
+	}
+	for v := fromVersion; v < toVersion; v++ {
+		fn := migrations[v]
+		migrated, err := fn(raw)
+		if err != nil {
+			return nil, fmt.Errorf("migration from format version %d to %d failed: %v", v, v+1, err)
+		}
+		raw = migrated
+	}
+	return raw, nil
+}
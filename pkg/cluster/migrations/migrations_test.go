@@ -0,0 +1,64 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrations
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMigrateV0ToV1IsIdentity(t *testing.T) {
+	raw := json.RawMessage(`{"max_standbys_per_sender":5}`)
+	migrated, err := Migrate(raw, 0, 1)
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	var got, want map[string]interface{}
+	if err := json.Unmarshal(migrated, &got); err != nil {
+		t.Fatalf("Unmarshal migrated: %v", err)
+	}
+	if err := json.Unmarshal(raw, &want); err != nil {
+		t.Fatalf("Unmarshal raw: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("migrated = %v, want %v", got, want)
+	}
+}
+
+func TestMigrateNoOpWhenAlreadyAtTarget(t *testing.T) {
+	raw := json.RawMessage(`{"max_standbys_per_sender":5}`)
+	migrated, err := Migrate(raw, 1, 1)
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if string(migrated) != string(raw) {
+		t.Errorf("migrated = %s, want unchanged %s", migrated, raw)
+	}
+}
+
+func TestMigrateRejectsOlderTarget(t *testing.T) {
+	raw := json.RawMessage(`{}`)
+	if _, err := Migrate(raw, 1, 0); err == nil {
+		t.Fatal("expected an error migrating to an older format version")
+	}
+}
+
+func TestMigrateRejectsUnknownTargetVersion(t *testing.T) {
+	raw := json.RawMessage(`{}`)
+	if _, err := Migrate(raw, 0, 42); err == nil {
+		t.Fatal("expected an error migrating to a format version with no migration path")
+	}
+}
@@ -0,0 +1,402 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// flatJSON returns the JSON representation of c's fields alone, without the
+// cfgFormat envelope added by MarshalJSON. It's the representation
+// ApplyJSONPatch/ApplyMergePatch operate on, and the one a caller diffing or
+// patching a NilConfig should reason about.
+func (c *NilConfig) flatJSON() ([]byte, error) {
+	// nilConfig is needed to avoid recursive infinite calls to
+	// NilConfig.MarshalJSON
+	type nilConfig NilConfig
+	return json.Marshal((*nilConfig)(c))
+}
+
+// nilConfigFromFlatJSON builds and validates a NilConfig from its flat (no
+// envelope) JSON representation.
+func nilConfigFromFlatJSON(raw []byte) (*NilConfig, error) {
+	// nilConfig is needed to avoid recursive infinite calls to
+	// NilConfig.UnmarshalJSON
+	type nilConfig NilConfig
+	var nc nilConfig
+	if err := json.Unmarshal(raw, &nc); err != nil {
+		return nil, err
+	}
+	c := NilConfig(nc)
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("config validation failed: %v", err)
+	}
+	return &c, nil
+}
+
+// ApplyMergePatch applies patch, a RFC 7396 JSON Merge Patch, to c and
+// returns the resulting, validated NilConfig. Keys set to null in patch are
+// removed (e.g. {"pg_parameters": {"work_mem": null}} deletes the
+// work_mem entry), matching entries are merged recursively, and any other
+// value replaces the existing one wholesale.
+func (c *NilConfig) ApplyMergePatch(patch []byte) (*NilConfig, error) {
+	var patchDoc interface{}
+	if err := json.Unmarshal(patch, &patchDoc); err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %v", err)
+	}
+
+	cur, err := c.flatJSON()
+	if err != nil {
+		return nil, err
+	}
+	var curDoc interface{}
+	if err := json.Unmarshal(cur, &curDoc); err != nil {
+		return nil, err
+	}
+
+	merged, err := json.Marshal(mergePatch(curDoc, patchDoc))
+	if err != nil {
+		return nil, err
+	}
+	return nilConfigFromFlatJSON(merged)
+}
+
+// mergePatch implements the RFC 7396 MergePatch algorithm for decoded JSON
+// values (map[string]interface{}, []interface{}, or scalars).
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		// The patch isn't a JSON object: it replaces target wholesale.
+		return patch
+	}
+	targetObj, _ := target.(map[string]interface{})
+	result := map[string]interface{}{}
+	for k, v := range targetObj {
+		result[k] = v
+	}
+	for k, v := range patchObj {
+		if v == nil {
+			delete(result, k)
+			continue
+		}
+		result[k] = mergePatch(result[k], v)
+	}
+	return result
+}
+
+// jsonPatchOp is a single RFC 6902 JSON Patch operation.
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ApplyJSONPatch applies patch, a RFC 6902 JSON Patch document (add, remove,
+// replace, move, copy and test operations), to c and returns the resulting,
+// validated NilConfig.
+func (c *NilConfig) ApplyJSONPatch(patch []byte) (*NilConfig, error) {
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("invalid json patch: %v", err)
+	}
+
+	cur, err := c.flatJSON()
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(cur, &doc); err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		doc, err = applyJSONPatchOp(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%q %q): %v", i, op.Op, op.Path, err)
+		}
+	}
+
+	patched, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return nilConfigFromFlatJSON(patched)
+}
+
+func applyJSONPatchOp(doc interface{}, op jsonPatchOp) (interface{}, error) {
+	switch op.Op {
+	case "add":
+		var v interface{}
+		if err := json.Unmarshal(op.Value, &v); err != nil {
+			return nil, fmt.Errorf("invalid value: %v", err)
+		}
+		return pointerSet(doc, op.Path, v, true)
+	case "replace":
+		var v interface{}
+		if err := json.Unmarshal(op.Value, &v); err != nil {
+			return nil, fmt.Errorf("invalid value: %v", err)
+		}
+		return pointerSet(doc, op.Path, v, false)
+	case "remove":
+		return pointerRemove(doc, op.Path)
+	case "move":
+		v, err := pointerGet(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		doc, err = pointerRemove(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return pointerSet(doc, op.Path, v, true)
+	case "copy":
+		v, err := pointerGet(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return pointerSet(doc, op.Path, deepCopyJSON(v), true)
+	case "test":
+		var v interface{}
+		if err := json.Unmarshal(op.Value, &v); err != nil {
+			return nil, fmt.Errorf("invalid value: %v", err)
+		}
+		cur, err := pointerGet(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(cur, v) {
+			return nil, fmt.Errorf("test failed: value at %q doesn't match", op.Path)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unsupported operation %q", op.Op)
+	}
+}
+
+// splitPointer splits a RFC 6901 JSON Pointer into its unescaped reference
+// tokens. The empty pointer ("") refers to the whole document.
+func splitPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid json pointer %q: must start with \"/\"", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		t = strings.ReplaceAll(t, "~1", "/")
+		t = strings.ReplaceAll(t, "~0", "~")
+		tokens[i] = t
+	}
+	return tokens, nil
+}
+
+// joinPointer is the inverse of splitPointer: it rebuilds a RFC 6901
+// pointer from its unescaped reference tokens, returning "" (the whole
+// document) for an empty token list.
+func joinPointer(tokens []string) string {
+	if len(tokens) == 0 {
+		return ""
+	}
+	escaped := make([]string, len(tokens))
+	for i, t := range tokens {
+		t = strings.ReplaceAll(t, "~", "~0")
+		t = strings.ReplaceAll(t, "/", "~1")
+		escaped[i] = t
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+func pointerGet(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	cur := doc
+	for _, t := range tokens {
+		next, err := containerGet(cur, t)
+		if err != nil {
+			return nil, err
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// pointerSet returns doc with value set at pointer. If allowCreate is true
+// (JSON Patch "add") a missing object key or the "-" array index creates a
+// new entry; otherwise (JSON Patch "replace") the pointer must already
+// exist.
+func pointerSet(doc interface{}, pointer string, value interface{}, allowCreate bool) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parentTokens := tokens[:len(tokens)-1]
+	var parent interface{} = doc
+	if len(parentTokens) > 0 {
+		var err error
+		parent, err = pointerGet(doc, joinPointer(parentTokens))
+		if err != nil {
+			return nil, err
+		}
+	}
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		if !allowCreate {
+			if _, ok := p[last]; !ok {
+				return nil, fmt.Errorf("key %q not found", last)
+			}
+		}
+		p[last] = value
+	case []interface{}:
+		if last == "-" {
+			if !allowCreate {
+				return nil, fmt.Errorf("cannot replace array element \"-\"")
+			}
+			return doc, appendInPlace(doc, parentTokens, value)
+		}
+		idx, err := strconv.Atoi(last)
+		if err != nil || idx < 0 || idx > len(p) {
+			return nil, fmt.Errorf("invalid array index %q", last)
+		}
+		if idx == len(p) {
+			if !allowCreate {
+				return nil, fmt.Errorf("array index %q out of bounds", last)
+			}
+			return doc, appendInPlace(doc, parentTokens, value)
+		}
+		if allowCreate {
+			// JSON Patch "add" inserts at idx, shifting later elements right.
+			newArr := append(append(append([]interface{}{}, p[:idx]...), value), p[idx:]...)
+			return doc, setArray(doc, parentTokens, newArr)
+		}
+		p[idx] = value
+	default:
+		return nil, fmt.Errorf("cannot set a value inside a non-container")
+	}
+	return doc, nil
+}
+
+// appendInPlace appends value to the array found at the (already
+// navigated) parentTokens path, replacing it in its own parent since
+// appending may reallocate the underlying slice.
+func appendInPlace(doc interface{}, parentTokens []string, value interface{}) error {
+	pointer := joinPointer(parentTokens)
+	arrVal, err := pointerGet(doc, pointer)
+	if err != nil {
+		return err
+	}
+	arr, ok := arrVal.([]interface{})
+	if !ok {
+		return fmt.Errorf("%q is not an array", pointer)
+	}
+	arr = append(arr, value)
+	_, err = pointerSet(doc, pointer, arr, true)
+	return err
+}
+
+func pointerRemove(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole document")
+	}
+	parentTokens := tokens[:len(tokens)-1]
+	parent := interface{}(doc)
+	if len(parentTokens) > 0 {
+		var err error
+		parent, err = pointerGet(doc, joinPointer(parentTokens))
+		if err != nil {
+			return nil, err
+		}
+	}
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := p[last]; !ok {
+			return nil, fmt.Errorf("key %q not found", last)
+		}
+		delete(p, last)
+	case []interface{}:
+		idx, err := strconv.Atoi(last)
+		if err != nil || idx < 0 || idx >= len(p) {
+			return nil, fmt.Errorf("invalid array index %q", last)
+		}
+		newArr := append(append([]interface{}{}, p[:idx]...), p[idx+1:]...)
+		return doc, setArray(doc, parentTokens, newArr)
+	default:
+		return nil, fmt.Errorf("cannot remove a value from a non-container")
+	}
+	return doc, nil
+}
+
+func setArray(doc interface{}, parentTokens []string, arr []interface{}) error {
+	pointer := joinPointer(parentTokens)
+	_, err := pointerSet(doc, pointer, interface{}(arr), true)
+	return err
+}
+
+// deepCopyJSON returns a copy of a decoded JSON value (map/slice/scalar)
+// that shares no containers with v, so a "copy" operation's destination can
+// be mutated independently of its source.
+func deepCopyJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			c[k] = deepCopyJSON(e)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(t))
+		for i, e := range t {
+			c[i] = deepCopyJSON(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+func containerGet(cur interface{}, token string) (interface{}, error) {
+	switch v := cur.(type) {
+	case map[string]interface{}:
+		val, ok := v[token]
+		if !ok {
+			return nil, fmt.Errorf("key %q not found", token)
+		}
+		return val, nil
+	case []interface{}:
+		idx, err := strconv.Atoi(token)
+		if err != nil || idx < 0 || idx >= len(v) {
+			return nil, fmt.Errorf("invalid array index %q", token)
+		}
+		return v[idx], nil
+	default:
+		return nil, fmt.Errorf("cannot navigate into a non-container value")
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyMergePatchDeletesPGParameter(t *testing.T) {
+	nc := &NilConfig{
+		PGParameters: MapStringP(map[string]string{
+			"work_mem":       "4MB",
+			"shared_buffers": "1GB",
+		}),
+	}
+
+	patched, err := nc.ApplyMergePatch([]byte(`{"pg_parameters":{"work_mem":null}}`))
+	if err != nil {
+		t.Fatalf("ApplyMergePatch: %v", err)
+	}
+
+	want := map[string]string{"shared_buffers": "1GB"}
+	if got := *patched.PGParameters; !reflect.DeepEqual(got, want) {
+		t.Errorf("pg_parameters = %v, want %v", got, want)
+	}
+}
+
+func TestApplyMergePatchAddsPGParameter(t *testing.T) {
+	nc := &NilConfig{
+		PGParameters: MapStringP(map[string]string{"work_mem": "4MB"}),
+	}
+
+	patched, err := nc.ApplyMergePatch([]byte(`{"pg_parameters":{"max_connections":"200"}}`))
+	if err != nil {
+		t.Fatalf("ApplyMergePatch: %v", err)
+	}
+
+	want := map[string]string{"work_mem": "4MB", "max_connections": "200"}
+	if got := *patched.PGParameters; !reflect.DeepEqual(got, want) {
+		t.Errorf("pg_parameters = %v, want %v", got, want)
+	}
+}
+
+func TestApplyJSONPatchReplace(t *testing.T) {
+	nc := &NilConfig{MaxStandbysPerSender: UintP(3)}
+
+	patched, err := nc.ApplyJSONPatch([]byte(`[{"op":"replace","path":"/max_standbys_per_sender","value":5}]`))
+	if err != nil {
+		t.Fatalf("ApplyJSONPatch: %v", err)
+	}
+	if got := *patched.MaxStandbysPerSender; got != 5 {
+		t.Errorf("max_standbys_per_sender = %d, want 5", got)
+	}
+}
+
+func TestApplyJSONPatchInvalidResultFailsValidation(t *testing.T) {
+	nc := &NilConfig{}
+	_, err := nc.ApplyJSONPatch([]byte(`[{"op":"add","path":"/min_synchronous_standbys","value":0}]`))
+	if err == nil {
+		t.Fatal("expected an error patching min_synchronous_standbys to an invalid value")
+	}
+}
+
+// TestJSONPatchAddAtIndexInserts exercises the generic RFC 6902 "add"
+// semantics (insert, shifting later elements right) directly against the
+// patch engine's document model, since NilConfig itself has no array
+// fields to patch through the public API.
+func TestJSONPatchAddAtIndexInserts(t *testing.T) {
+	doc := map[string]interface{}{
+		"items": []interface{}{"a", "b", "c"},
+	}
+
+	got, err := applyJSONPatchOp(doc, jsonPatchOp{
+		Op:    "add",
+		Path:  "/items/1",
+		Value: []byte(`"x"`),
+	})
+	if err != nil {
+		t.Fatalf("applyJSONPatchOp: %v", err)
+	}
+
+	want := []interface{}{"a", "x", "b", "c"}
+	gotItems := got.(map[string]interface{})["items"]
+	if !reflect.DeepEqual(gotItems, want) {
+		t.Errorf("items = %v, want %v", gotItems, want)
+	}
+}
+
+// TestJSONPatchCopyDoesNotAlias ensures a "copy" operation doesn't leave
+// the destination sharing the same backing container as the source, so
+// mutating one doesn't mutate the other.
+func TestJSONPatchCopyDoesNotAlias(t *testing.T) {
+	doc := map[string]interface{}{
+		"a": map[string]interface{}{"x": float64(1)},
+	}
+
+	doc1, err := applyJSONPatchOp(doc, jsonPatchOp{Op: "copy", From: "/a", Path: "/b"})
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+
+	doc2, err := applyJSONPatchOp(doc1, jsonPatchOp{Op: "add", Path: "/b/x", Value: []byte(`2`)})
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	m := doc2.(map[string]interface{})
+	a := m["a"].(map[string]interface{})
+	b := m["b"].(map[string]interface{})
+	if a["x"] != float64(1) {
+		t.Errorf("source /a/x = %v, want unchanged 1 (copy must not alias)", a["x"])
+	}
+	if b["x"] != float64(2) {
+		t.Errorf("destination /b/x = %v, want 2", b["x"])
+	}
+}
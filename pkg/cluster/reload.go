@@ -0,0 +1,241 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ConfigHolder atomically holds the currently active *Config so it can be
+// read by a component's main loop while a ConfigWatcher swaps it for a
+// freshly reloaded one in between iterations, without requiring a restart.
+type ConfigHolder struct {
+	v atomic.Value
+}
+
+// NewConfigHolder returns a ConfigHolder initialized with c.
+func NewConfigHolder(c *Config) *ConfigHolder {
+	ch := &ConfigHolder{}
+	ch.Store(c)
+	return ch
+}
+
+// Load returns the currently active Config.
+func (ch *ConfigHolder) Load() *Config {
+	return ch.v.Load().(*Config)
+}
+
+// Store atomically replaces the active Config with c.
+func (ch *ConfigHolder) Store(c *Config) {
+	ch.v.Store(c)
+}
+
+// ReloadSafe reports whether the active config can be live swapped from c to
+// new (e.g. following a ConfigWatcher update) without restarting the
+// keeper/sentinel/proxy. It returns an error naming the first field that
+// changed but cannot be hot-applied.
+func (c *Config) ReloadSafe(new *Config) error {
+	if c.InitWithMultipleKeepers != new.InitWithMultipleKeepers {
+		return fmt.Errorf("init_with_multiple_keepers cannot be changed without restarting the cluster")
+	}
+	return nil
+}
+
+// PGParametersDiff returns the postgres parameters whose desired value
+// changed between old and new (added or changed), and the list of parameter
+// names present in old but removed in new. The config reload path uses this
+// to know which GUCs to apply via "ALTER SYSTEM SET"/"ALTER SYSTEM RESET"
+// before calling pg_reload_conf().
+func PGParametersDiff(old, new map[string]string) (changed map[string]string, removed []string) {
+	changed = map[string]string{}
+	for k, v := range new {
+		if ov, ok := old[k]; !ok || ov != v {
+			changed[k] = v
+		}
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	return changed, removed
+}
+
+// nonReloadableGUCs lists the postgres parameters that require a full
+// server restart and can't be applied via "SELECT pg_reload_conf()".
+var nonReloadableGUCs = map[string]bool{
+	"shared_buffers":         true,
+	"max_connections":        true,
+	"max_worker_processes":   true,
+	"max_wal_senders":        true,
+	"wal_level":              true,
+	"wal_log_hints":          true,
+	"track_commit_timestamp": true,
+}
+
+// PGExecutor is the minimal surface ApplyPGParameters needs to run SQL
+// against a running postgres instance; the keeper's database connection
+// implements it.
+type PGExecutor interface {
+	Exec(sql string, args ...interface{}) error
+}
+
+// ApplyPGParameters applies changed/removed postgres parameters (as
+// computed by PGParametersDiff) to a running instance via executor, issuing
+// "ALTER SYSTEM SET"/"ALTER SYSTEM RESET" and then "SELECT
+// pg_reload_conf()" for the GUCs that can be hot-applied. It returns the
+// sorted list of changed/removed parameter names that are in
+// nonReloadableGUCs, so the caller can flag them as pending-restart on the
+// keeper status in the clusterview instead of expecting them to take
+// effect immediately.
+func ApplyPGParameters(executor PGExecutor, changed map[string]string, removed []string) (pendingRestart []string, err error) {
+	reloadNeeded := false
+	for name, value := range changed {
+		if nonReloadableGUCs[name] {
+			pendingRestart = append(pendingRestart, name)
+			continue
+		}
+		quotedName, err := quoteGUCName(name)
+		if err != nil {
+			return pendingRestart, err
+		}
+		if err := executor.Exec(fmt.Sprintf("ALTER SYSTEM SET %s TO %s", quotedName, quoteGUCValue(value))); err != nil {
+			return pendingRestart, fmt.Errorf("failed to set %q: %v", name, err)
+		}
+		reloadNeeded = true
+	}
+	for _, name := range removed {
+		if nonReloadableGUCs[name] {
+			pendingRestart = append(pendingRestart, name)
+			continue
+		}
+		quotedName, err := quoteGUCName(name)
+		if err != nil {
+			return pendingRestart, err
+		}
+		if err := executor.Exec(fmt.Sprintf("ALTER SYSTEM RESET %s", quotedName)); err != nil {
+			return pendingRestart, fmt.Errorf("failed to reset %q: %v", name, err)
+		}
+		reloadNeeded = true
+	}
+	if reloadNeeded {
+		if err := executor.Exec("SELECT pg_reload_conf()"); err != nil {
+			return pendingRestart, fmt.Errorf("failed to reload config: %v", err)
+		}
+	}
+	sort.Strings(pendingRestart)
+	return pendingRestart, nil
+}
+
+// gucNameRE matches the identifiers postgres GUCs actually use: plain words
+// (work_mem, shared_buffers) and the "extension.setting" form custom GUCs
+// register under (auto_explain.log_min_duration). It's deliberately strict
+// rather than quoting-and-escaping, since a GUC name is never expected to
+// need anything outside this set and ALTER SYSTEM's target isn't a place to
+// take chances with unsanitized input.
+var gucNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)*$`)
+
+// quoteGUCName validates name as a safe, unambiguous postgres parameter
+// identifier to interpolate into an ALTER SYSTEM statement, returning it
+// unchanged if valid. Unlike quoteGUCValue, GUC names can't be single-quoted
+// (ALTER SYSTEM SET takes an identifier there, not a literal), so an
+// allowlisted charset is how this avoids SQL injection via a crafted
+// pg_parameters key.
+func quoteGUCName(name string) (string, error) {
+	if !gucNameRE.MatchString(name) {
+		return "", fmt.Errorf("invalid postgres parameter name %q", name)
+	}
+	return name, nil
+}
+
+func quoteGUCValue(v string) string {
+	return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+}
+
+// ConfigSource is the minimal surface a ConfigWatcher needs to poll for
+// cluster config changes; the etcd/consul-backed store implements it.
+type ConfigSource interface {
+	// GetConfig returns the currently stored NilConfig and an opaque
+	// revision that changes whenever the stored config is updated.
+	GetConfig() (nilConfig *NilConfig, revision uint64, err error)
+}
+
+// ConfigWatcher polls a ConfigSource on the cluster config key and
+// publishes a freshly computed *Config snapshot on C whenever the stored
+// revision changes, so keepers, sentinels and proxies can swap their active
+// config (via a ConfigHolder) between loop iterations instead of requiring
+// a restart to pick up tuning changes.
+type ConfigWatcher struct {
+	source   ConfigSource
+	interval time.Duration
+
+	C chan *Config
+
+	stopCh chan struct{}
+}
+
+// NewConfigWatcher returns a ConfigWatcher that polls source every
+// interval. Call Start to begin polling.
+func NewConfigWatcher(source ConfigSource, interval time.Duration) *ConfigWatcher {
+	return &ConfigWatcher{
+		source:   source,
+		interval: interval,
+		C:        make(chan *Config),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start begins polling source in a new goroutine.
+func (w *ConfigWatcher) Start() {
+	go w.run()
+}
+
+// Stop stops polling. It's safe to call Stop without ever reading from C.
+func (w *ConfigWatcher) Stop() {
+	close(w.stopCh)
+}
+
+func (w *ConfigWatcher) run() {
+	var lastRevision uint64
+	seen := false
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-time.After(w.interval):
+		}
+		nc, revision, err := w.source.GetConfig()
+		if err != nil {
+			// A transient store error just delays the next poll; the
+			// previous, still valid Config stays active until then.
+			continue
+		}
+		if seen && revision == lastRevision {
+			continue
+		}
+		seen = true
+		lastRevision = revision
+		select {
+		case w.C <- nc.ToConfig():
+		case <-w.stopCh:
+			return
+		}
+	}
+}
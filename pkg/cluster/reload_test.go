@@ -0,0 +1,151 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"reflect"
+	"testing"
+)
+
+type fakePGExecutor struct {
+	statements []string
+}
+
+func (e *fakePGExecutor) Exec(sql string, args ...interface{}) error {
+	e.statements = append(e.statements, sql)
+	return nil
+}
+
+func TestApplyPGParametersRejectsUnsafeGUCName(t *testing.T) {
+	executor := &fakePGExecutor{}
+	_, err := ApplyPGParameters(executor, map[string]string{
+		"work_mem; DROP TABLE users;--": "4MB",
+	}, nil)
+	if err == nil {
+		t.Fatal("expected an error applying an unsafe postgres parameter name")
+	}
+	if len(executor.statements) != 0 {
+		t.Errorf("expected no SQL to be issued, got %v", executor.statements)
+	}
+}
+
+func TestApplyPGParametersAllowsDottedExtensionGUCName(t *testing.T) {
+	executor := &fakePGExecutor{}
+	if _, err := ApplyPGParameters(executor, map[string]string{
+		"auto_explain.log_min_duration": "1s",
+	}, nil); err != nil {
+		t.Fatalf("ApplyPGParameters: %v", err)
+	}
+	want := []string{
+		"ALTER SYSTEM SET auto_explain.log_min_duration TO '1s'",
+		"SELECT pg_reload_conf()",
+	}
+	if !reflect.DeepEqual(executor.statements, want) {
+		t.Errorf("statements = %v, want %v", executor.statements, want)
+	}
+}
+
+func TestApplyPGParametersSkipsNonReloadableAsPendingRestart(t *testing.T) {
+	executor := &fakePGExecutor{}
+	pending, err := ApplyPGParameters(executor, map[string]string{
+		"shared_buffers": "2GB",
+	}, nil)
+	if err != nil {
+		t.Fatalf("ApplyPGParameters: %v", err)
+	}
+	if !reflect.DeepEqual(pending, []string{"shared_buffers"}) {
+		t.Errorf("pendingRestart = %v, want [shared_buffers]", pending)
+	}
+	if len(executor.statements) != 0 {
+		t.Errorf("expected no SQL issued for a non-reloadable GUC, got %v", executor.statements)
+	}
+}
+
+func TestApplyPGParametersRemovedResetsGUC(t *testing.T) {
+	executor := &fakePGExecutor{}
+	if _, err := ApplyPGParameters(executor, nil, []string{"work_mem"}); err != nil {
+		t.Fatalf("ApplyPGParameters: %v", err)
+	}
+	want := []string{"ALTER SYSTEM RESET work_mem", "SELECT pg_reload_conf()"}
+	if !reflect.DeepEqual(executor.statements, want) {
+		t.Errorf("statements = %v, want %v", executor.statements, want)
+	}
+}
+
+func TestPGParametersDiffAddedChangedRemoved(t *testing.T) {
+	old := map[string]string{"work_mem": "4MB", "max_connections": "100"}
+	new := map[string]string{"work_mem": "8MB", "shared_buffers": "1GB"}
+
+	changed, removed := PGParametersDiff(old, new)
+	if !reflect.DeepEqual(changed, map[string]string{"work_mem": "8MB", "shared_buffers": "1GB"}) {
+		t.Errorf("changed = %v", changed)
+	}
+	if !reflect.DeepEqual(removed, []string{"max_connections"}) {
+		t.Errorf("removed = %v, want [max_connections]", removed)
+	}
+}
+
+func TestReloadSafeRejectsInitWithMultipleKeepersChange(t *testing.T) {
+	old := &Config{InitWithMultipleKeepers: false}
+	new := &Config{InitWithMultipleKeepers: true}
+	if err := old.ReloadSafe(new); err == nil {
+		t.Fatal("expected an error reloading across an init_with_multiple_keepers change")
+	}
+}
+
+func TestReloadSafeAllowsOtherFieldChanges(t *testing.T) {
+	old := &Config{InitWithMultipleKeepers: false, MaxStandbysPerSender: 3}
+	new := &Config{InitWithMultipleKeepers: false, MaxStandbysPerSender: 5}
+	if err := old.ReloadSafe(new); err != nil {
+		t.Fatalf("ReloadSafe: %v", err)
+	}
+}
+
+func TestConfigWatcherPublishesOnRevisionChange(t *testing.T) {
+	source := &fakeConfigSource{
+		configs: []*NilConfig{
+			{MaxStandbysPerSender: UintP(3)},
+			{MaxStandbysPerSender: UintP(5)},
+		},
+		revisions: []uint64{1, 2},
+	}
+	w := NewConfigWatcher(source, 0)
+	w.Start()
+	defer w.Stop()
+
+	<-w.C
+	got := <-w.C
+	if got.MaxStandbysPerSender != 5 {
+		t.Errorf("MaxStandbysPerSender = %d, want 5 (the latest revision)", got.MaxStandbysPerSender)
+	}
+}
+
+// fakeConfigSource always returns the last entry in configs/revisions once
+// the watcher has polled past the earlier ones, simulating a store that's
+// already moved on by the time the watcher's goroutine gets scheduled.
+type fakeConfigSource struct {
+	configs   []*NilConfig
+	revisions []uint64
+	calls     int
+}
+
+func (s *fakeConfigSource) GetConfig() (*NilConfig, uint64, error) {
+	i := s.calls
+	if i >= len(s.configs) {
+		i = len(s.configs) - 1
+	}
+	s.calls++
+	return s.configs[i], s.revisions[i], nil
+}
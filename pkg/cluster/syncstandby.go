@@ -0,0 +1,111 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SyncStandby is the subset of a keeper's state the sentinel's
+// master-followers selection needs to decide whether it's eligible as a
+// synchronous standby.
+type SyncStandby struct {
+	// UID is the keeper unique id, used as its entry in
+	// synchronous_standby_names.
+	UID string
+	// Tags are the keeper's tags (e.g. {"dc": "fra1"}), matched against
+	// NilConfig.SynchronousStandbyTags.
+	Tags map[string]string
+	// Healthy reports whether the sentinel currently considers this keeper
+	// reachable.
+	Healthy bool
+}
+
+// EligibleSyncStandbys filters standbys down to the ones currently healthy
+// and matching every tag in requiredTags (NilConfig.SynchronousStandbyTags),
+// sorted by UID so selection/priority order is stable across calls.
+func EligibleSyncStandbys(standbys []SyncStandby, requiredTags map[string]string) []SyncStandby {
+	eligible := make([]SyncStandby, 0, len(standbys))
+	for _, s := range standbys {
+		if !s.Healthy {
+			continue
+		}
+		if !matchesTags(s.Tags, requiredTags) {
+			continue
+		}
+		eligible = append(eligible, s)
+	}
+	sort.Slice(eligible, func(i, j int) bool { return eligible[i].UID < eligible[j].UID })
+	return eligible
+}
+
+func matchesTags(tags, required map[string]string) bool {
+	for k, v := range required {
+		if tags[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// SynchronousStandbyNames builds the value for PostgreSQL's
+// synchronous_standby_names GUC from the eligible standbys (as returned by
+// EligibleSyncStandbys) and c's synchronous replication tuning. It returns
+// "" when synchronous replication isn't enabled or no standby is eligible.
+func SynchronousStandbyNames(c *Config, eligible []SyncStandby) string {
+	if !c.SynchronousReplication || len(eligible) == 0 {
+		return ""
+	}
+	n := c.MaxSynchronousStandbys
+	if n == 0 || int(n) > len(eligible) {
+		n = uint(len(eligible))
+	}
+	names := make([]string, n)
+	for i := uint(0); i < n; i++ {
+		names[i] = eligible[i].UID
+	}
+	k := c.MinSynchronousStandbys
+	if k > n {
+		// Fewer standbys are listed than required by config (eligible
+		// shrank below MaxSynchronousStandbys): ask postgres to wait on
+		// only as many acks as are actually named, rather than a k it can
+		// never satisfy.
+		k = n
+	}
+	if c.SynchronousStandbysPolicy == SyncStandbysPolicyFirst {
+		return fmt.Sprintf("FIRST %d (%s)", k, strings.Join(names, ","))
+	}
+	// "any" and "quorum" both use PostgreSQL's ANY k (...) form. CanPromote
+	// below applies its MinSynchronousStandbys check under every policy, so
+	// quorum is handled the same as any.
+	return fmt.Sprintf("ANY %d (%s)", k, strings.Join(names, ","))
+}
+
+// CanPromote reports whether accepting eligible as the new master's
+// synchronous standbys is safe. When synchronous replication is enabled, a
+// failover/master change must be refused if fewer than
+// MinSynchronousStandbys eligible standbys are currently reachable, since
+// promoting anyway could silently lose synchronously committed transactions.
+func CanPromote(c *Config, eligible []SyncStandby) error {
+	if !c.SynchronousReplication {
+		return nil
+	}
+	if uint(len(eligible)) < c.MinSynchronousStandbys {
+		return fmt.Errorf("cannot promote: only %d synchronous standby(s) reachable, minimum required is %d", len(eligible), c.MinSynchronousStandbys)
+	}
+	return nil
+}
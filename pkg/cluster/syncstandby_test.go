@@ -0,0 +1,103 @@
+// Copyright 2015 Sorint.lab
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEligibleSyncStandbysFiltersUnhealthyAndTags(t *testing.T) {
+	standbys := []SyncStandby{
+		{UID: "b", Healthy: true, Tags: map[string]string{"dc": "fra1"}},
+		{UID: "a", Healthy: true, Tags: map[string]string{"dc": "fra1"}},
+		{UID: "c", Healthy: false, Tags: map[string]string{"dc": "fra1"}},
+		{UID: "d", Healthy: true, Tags: map[string]string{"dc": "ams1"}},
+	}
+
+	got := EligibleSyncStandbys(standbys, map[string]string{"dc": "fra1"})
+	want := []SyncStandby{
+		{UID: "a", Healthy: true, Tags: map[string]string{"dc": "fra1"}},
+		{UID: "b", Healthy: true, Tags: map[string]string{"dc": "fra1"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EligibleSyncStandbys = %v, want %v", got, want)
+	}
+}
+
+func TestSynchronousStandbyNamesEmptyWhenDisabledOrNoEligible(t *testing.T) {
+	c := &Config{SynchronousReplication: false}
+	if got := SynchronousStandbyNames(c, []SyncStandby{{UID: "a"}}); got != "" {
+		t.Errorf("got %q, want empty when synchronous replication is disabled", got)
+	}
+
+	c = &Config{SynchronousReplication: true}
+	if got := SynchronousStandbyNames(c, nil); got != "" {
+		t.Errorf("got %q, want empty when no standby is eligible", got)
+	}
+}
+
+func TestSynchronousStandbyNamesAnyAndFirst(t *testing.T) {
+	eligible := []SyncStandby{{UID: "a"}, {UID: "b"}, {UID: "c"}}
+	c := &Config{
+		SynchronousReplication:    true,
+		MinSynchronousStandbys:    1,
+		MaxSynchronousStandbys:    2,
+		SynchronousStandbysPolicy: SyncStandbysPolicyAny,
+	}
+	if got, want := SynchronousStandbyNames(c, eligible), "ANY 1 (a,b)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	c.SynchronousStandbysPolicy = SyncStandbysPolicyFirst
+	if got, want := SynchronousStandbyNames(c, eligible), "FIRST 1 (a,b)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestSynchronousStandbyNamesClampsMinToListedCount ensures that when fewer
+// standbys end up listed than MinSynchronousStandbys (eligible shrank below
+// MaxSynchronousStandbys), the generated synchronous_standby_names never
+// asks postgres for more acks than there are names in the list, which
+// postgres would otherwise treat as permanently unsatisfiable.
+func TestSynchronousStandbyNamesClampsMinToListedCount(t *testing.T) {
+	eligible := []SyncStandby{{UID: "a"}}
+	c := &Config{
+		SynchronousReplication:    true,
+		MinSynchronousStandbys:    3,
+		MaxSynchronousStandbys:    3,
+		SynchronousStandbysPolicy: SyncStandbysPolicyAny,
+	}
+	if got, want := SynchronousStandbyNames(c, eligible), "ANY 1 (a)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCanPromoteRequiresMinEligible(t *testing.T) {
+	c := &Config{SynchronousReplication: true, MinSynchronousStandbys: 2}
+	if err := CanPromote(c, []SyncStandby{{UID: "a"}}); err == nil {
+		t.Fatal("expected an error promoting with fewer than MinSynchronousStandbys eligible")
+	}
+	if err := CanPromote(c, []SyncStandby{{UID: "a"}, {UID: "b"}}); err != nil {
+		t.Errorf("CanPromote: %v", err)
+	}
+}
+
+func TestCanPromoteIgnoresSyncStandbysWhenReplicationDisabled(t *testing.T) {
+	c := &Config{SynchronousReplication: false, MinSynchronousStandbys: 5}
+	if err := CanPromote(c, nil); err != nil {
+		t.Errorf("CanPromote: %v", err)
+	}
+}